@@ -2,7 +2,8 @@ package blocklist
 
 import (
 	"context"
-	"strings"
+	"net"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/metrics"
@@ -15,22 +16,50 @@ import (
 var log = clog.NewWithPlugin("blocklist")
 
 type Blocklist struct {
-	blockDomains      map[string]bool
-	allowDomains      map[string]bool
-	Next              plugin.Handler
-	domainMetrics     bool
-	blockResponse     int
-	blocklistLocation string
-	allowlistLocation string
-	bootStrapDNS      string
+	blockLists    []*NamedList
+	allowLists    []*NamedList
+	Next          plugin.Handler
+	domainMetrics bool
+	blockResponse int
+	bootStrapDNS  string
+	cnameCheck    bool
+	reload        time.Duration
+
+	// sinkhole, when true, makes blockResponseMsg synthesize an
+	// A/AAAA/SOA answer instead of using blockResponse as a bare RCODE.
+	sinkhole   bool
+	sinkholeV4 net.IP
+	sinkholeV6 net.IP
+	blockTTL   uint32
+	soa        *dns.SOA
+
+	// clientPolicies let a block/allowlist pair be scoped to queries from
+	// a given source network, layered on top of the global lists. The
+	// first matching policy, in configuration order, applies.
+	clientPolicies []*ClientPolicy
+
+	// queryLog, when non-nil, receives one QueryLogRecord per decision
+	// ServeDNS reaches. It is nil unless the querylog directive is set.
+	queryLog         *queryLogger
+	querylogPath     string
+	querylogMaxSize  int64
+	querylogMaxFiles int
+
+	// querylogHTTPAddr, when set, starts a standalone HTTP listener
+	// serving the querylog's recent records; it is independent of (and
+	// not reachable through) CoreDNS's own metrics listener. See
+	// startQueryLog.
+	querylogHTTPAddr string
 }
 
 func New() *Blocklist {
 	b := &Blocklist{
-		blockDomains:  make(map[string]bool),
-		allowDomains:  make(map[string]bool),
-		domainMetrics: false,
-		blockResponse: dns.RcodeNameError,
+		domainMetrics:    false,
+		blockResponse:    dns.RcodeNameError,
+		blockTTL:         3600,
+		soa:              defaultSOA,
+		querylogMaxSize:  defaultQuerylogMaxSize,
+		querylogMaxFiles: defaultQuerylogMaxFiles,
 	}
 	return b
 }
@@ -39,101 +68,254 @@ func New() *Blocklist {
 // A count of metrics around the blocking and allowing status is maintained
 // It returns the DNS RCODE
 func (b Blocklist) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	start := time.Now()
 	state := request.Request{W: w, Req: r}
 
-	shouldBlock, shouldAllow := b.shouldBlock(state.Name())
+	policy := b.matchClientPolicy(clientIP(state))
+	clientLabel := clientMetricLabel(policy)
 
-	if shouldBlock {
-		// If an RR should be both blocked and allowed,
-		// then allow it and update appropriate metrics
-		if shouldAllow {
-			allowCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
-			if b.domainMetrics {
-				allowWithDomainsCount.WithLabelValues(metrics.WithServer(ctx), state.Name()).Inc()
-			}
+	isBlocked, isAllowed, listID := b.shouldBlockForClient(state.Name(), policy)
+
+	switch {
+	case isAllowed:
+		allowCount.WithLabelValues(metrics.WithServer(ctx), listID, clientLabel).Inc()
+		if b.domainMetrics {
+			allowWithDomainsCount.WithLabelValues(metrics.WithServer(ctx), state.Name(), listID, clientLabel).Inc()
+		}
+		b.logQuery(state, start, decisionAllowed, b.ruleTextForList(state.Name(), listID, policy), listID, 0)
+
+	case isBlocked:
+		resp := b.blockResponseMsg(r)
+		if err := w.WriteMsg(resp); err != nil {
+			log.Errorf("failed to write block for %s, %v+", state.Name(), err)
+		}
+
+		blockCount.WithLabelValues(metrics.WithServer(ctx), listID, clientLabel).Inc()
+		if b.domainMetrics {
+			blockWithDomainsCount.WithLabelValues(metrics.WithServer(ctx), state.Name(), listID, clientLabel).Inc()
+		}
+
+		log.Debugf(
+			"blocked \"%s IN %s %s\" from %s (list %s)",
+			state.Type(),
+			state.Name(),
+			state.Proto(),
+			state.RemoteAddr(),
+			listID,
+		)
+
+		b.logQuery(state, start, decisionBlocked, b.ruleTextForList(state.Name(), listID, policy), listID, resp.Rcode)
+
+		return resp.Rcode, nil
+	}
 
-		} else {
-			// Handle the blocking of the RR
-			resp := new(dns.Msg)
-			resp.SetRcode(r, b.blockResponse)
-			err := w.WriteMsg(resp)
-			if err != nil {
-				log.Errorf("failed to write block for %s, %v+", state.Name(), err)
+	if !b.cnameCheck {
+		rcode, err := plugin.NextOrFailure(b.Name(), b.Next, ctx, w, r)
+		if !isAllowed {
+			b.logQuery(state, start, decisionPassed, "", "", rcode)
+		}
+		return rcode, err
+	}
+
+	return b.serveDNSWithCNAMECheck(ctx, w, r, state, policy, start, isAllowed)
+}
+
+// serveDNSWithCNAMECheck calls the next plugin through an interceptor so
+// the upstream answer can be inspected for CNAME cloaking before it is
+// written to the client: a query for a name that is not itself blocked
+// can still resolve,
+// via a chain of CNAMEs, to a name that is on the blocklist.
+//
+// qnameAllowed is true when the QNAME itself already matched an
+// allowlist in ServeDNS. The allowlist always wins, so in that case the
+// CNAME target is never checked — doing so could still block a query
+// ServeDNS already allowed and logged, overriding the allowlist and
+// double-counting the decision.
+func (b Blocklist) serveDNSWithCNAMECheck(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, state request.Request, policy *ClientPolicy, start time.Time, qnameAllowed bool) (int, error) {
+	icw := &cnameInterceptor{ResponseWriter: w}
+
+	rcode, err := plugin.NextOrFailure(b.Name(), b.Next, ctx, icw, r)
+	if err != nil || icw.msg == nil {
+		if !qnameAllowed {
+			b.logQuery(state, start, decisionPassed, "", "", rcode)
+		}
+		return rcode, err
+	}
+
+	if !qnameAllowed {
+		if target, listID, blocked := b.blockedCNAMETarget(icw.msg, policy); blocked {
+			resp := b.blockResponseMsg(r)
+			if werr := w.WriteMsg(resp); werr != nil {
+				log.Errorf("failed to write block for %s, %v+", state.Name(), werr)
 			}
 
-			blockCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
+			clientLabel := clientMetricLabel(policy)
+			blockCount.WithLabelValues(metrics.WithServer(ctx), listID, clientLabel).Inc()
+			cnameBlockCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
 			if b.domainMetrics {
-				blockWithDomainsCount.WithLabelValues(metrics.WithServer(ctx), state.Name()).Inc()
+				blockWithDomainsCount.WithLabelValues(metrics.WithServer(ctx), state.Name(), listID, clientLabel).Inc()
 			}
 
 			log.Debugf(
-				"blocked \"%s IN %s %s\" from %s",
+				"blocked \"%s IN %s %s\" from %s via CNAME target %s (list %s)",
 				state.Type(),
 				state.Name(),
 				state.Proto(),
 				state.RemoteAddr(),
+				target,
+				listID,
 			)
 
-			return b.blockResponse, nil
+			b.logQuery(state, start, decisionBlocked, "CNAME target "+target, listID, resp.Rcode)
+
+			return resp.Rcode, nil
 		}
 	}
 
-	return plugin.NextOrFailure(b.Name(), b.Next, ctx, w, r)
+	if !qnameAllowed {
+		b.logQuery(state, start, decisionPassed, "", "", icw.msg.Rcode)
+	}
+
+	return rcode, w.WriteMsg(icw.msg)
 }
 
-// shouldBlock checks for the presence of a DNS name in the block and allow lists
-// It returns the blockList and allowList status for that RR
-func (b Blocklist) shouldBlock(name string) (isBlocked bool, isAllowed bool) {
-	log.Debugf("shouldBlock(%s)", name)
+// blockedCNAMETarget walks the CNAME, A and AAAA records in an upstream
+// answer and runs each target name through shouldBlockForClient. It
+// returns the first name that is blocked and not explicitly allowed,
+// along with the ID of the list that blocked it.
+func (b Blocklist) blockedCNAMETarget(res *dns.Msg, policy *ClientPolicy) (target string, listID string, blocked bool) {
+	for _, rr := range res.Answer {
+		switch v := rr.(type) {
+		case *dns.CNAME:
+			target = v.Target
+		case *dns.A:
+			target = v.Hdr.Name
+		case *dns.AAAA:
+			target = v.Hdr.Name
+		default:
+			continue
+		}
 
-	if name == "localhost." {
-		return false, false
+		isBlocked, isAllowed, id := b.shouldBlockForClient(target, policy)
+		if isBlocked && !isAllowed {
+			return target, id, true
+		}
 	}
 
-	isBlocked = inList(name, b.blockDomains)
-	isAllowed = inList(name, b.allowDomains)
+	return "", "", false
+}
+
+// cnameInterceptor captures the upstream response instead of writing it
+// to the client, so ServeDNS can inspect it first.
+type cnameInterceptor struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
 
-	return isBlocked, isAllowed
+func (w *cnameInterceptor) WriteMsg(res *dns.Msg) error {
+	w.msg = res
+	return nil
 }
 
-func inList(name string, domainList map[string]bool) bool {
-	inList := false
+// logQuery writes a QueryLogRecord for the decision ServeDNS just
+// reached, if the querylog directive is configured. It is a no-op
+// otherwise, so it is safe to call unconditionally.
+func (b Blocklist) logQuery(state request.Request, start time.Time, decision, rule, list string, upstreamRcode int) {
+	if b.queryLog == nil {
+		return
+	}
+
+	rec := QueryLogRecord{
+		Time:          start,
+		Client:        state.IP(),
+		QName:         state.Name(),
+		QType:         state.Type(),
+		Rule:          rule,
+		List:          list,
+		Decision:      decision,
+		ElapsedMicros: time.Since(start).Microseconds(),
+	}
+	if decision == decisionPassed {
+		rec.UpstreamRcode = dns.RcodeToString[upstreamRcode]
+	}
+
+	b.queryLog.log(rec)
+}
 
-	nameParts := strings.Split(name, ".")
-	for i := range nameParts {
-		n := strings.Join(nameParts[i:], ".")
+// ruleTextForList re-matches name against the list or client policy
+// identified by listID to recover the literal rule line responsible,
+// for the querylog. It returns "" at once when querylog is not
+// configured, so the extra lookup is only made for queries that are
+// being logged.
+func (b Blocklist) ruleTextForList(name, listID string, policy *ClientPolicy) string {
+	if b.queryLog == nil {
+		return ""
+	}
 
-		// Because of how domains are passed through, the final iteration
-		// of the joined array will be a zero-length string
-		// Manually override that to be the DNS root RR
-		if len(n) == 0 {
-			n = "."
+	for _, nl := range b.allowLists {
+		if nl.ID == listID {
+			return nl.rules.rule(name)
+		}
+	}
+	for _, nl := range b.blockLists {
+		if nl.ID == listID {
+			return nl.rules.rule(name)
 		}
+	}
 
-		if _, inList = domainList[n]; inList {
-			break
+	if policy != nil && listID == policy.label() {
+		if rule := policy.Allow.rule(name); rule != "" {
+			return rule
+		}
+		if rule := policy.Block.rule(name); rule != "" {
+			return rule
 		}
 	}
 
-	return inList
+	return ""
 }
 
-func (b Blocklist) readBlocklist() {
-	blocklist, err := loadList(b.blocklistLocation, b.bootStrapDNS)
-	if err != nil {
-		return // plugin.Error("blocklist", err)
+// shouldBlock checks name against every configured allowlist and
+// blocklist, in order. An allowlist match always wins, mirroring the
+// original single-list behavior. It returns the blocked/allowed status
+// for name plus the ID of the list responsible for that status.
+func (b Blocklist) shouldBlock(name string) (isBlocked bool, isAllowed bool, listID string) {
+	log.Debugf("shouldBlock(%s)", name)
+
+	if name == "localhost." {
+		return false, false, ""
 	}
 
-	b.blockDomains = toMap(blocklist)
-	log.Infof("Loaded blocklist with %d entries", len(b.blockDomains))
+	for _, nl := range b.allowLists {
+		if _, allowed, _ := nl.rules.match(name); allowed {
+			return false, true, nl.ID
+		}
+	}
 
-	if b.allowlistLocation != "" {
-		allowlist, err := loadList(b.allowlistLocation, b.bootStrapDNS)
-		if err != nil {
-			return // plugin.Error("blocklist", err)
+	for _, nl := range b.blockLists {
+		blocked, allowed, _ := nl.rules.match(name)
+		if allowed {
+			return false, true, nl.ID
+		}
+		if blocked {
+			return true, false, nl.ID
 		}
-		b.allowDomains = toMap(allowlist)
-		log.Infof("Loaded allowlist with %d entries", len(b.allowDomains))
+	}
+
+	return false, false, ""
+}
+
+func (b Blocklist) readBlocklist() {
+	for _, nl := range b.blockLists {
+		nl.load(b.bootStrapDNS)
+	}
+
+	for _, nl := range b.allowLists {
+		nl.load(b.bootStrapDNS)
+	}
+
+	for _, p := range b.clientPolicies {
+		p.load(b.bootStrapDNS)
 	}
 }
 
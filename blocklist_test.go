@@ -19,6 +19,16 @@ func NextHandler() test.Handler {
 	})
 }
 
+// setRules compiles block rule lines into a "default" blocklist on x and,
+// when allow is non-empty, allow rule lines into a "default-allow"
+// allowlist, the way readBlocklist would after a load.
+func setRules(x *Blocklist, block, allow []string) {
+	x.blockLists = []*NamedList{{ID: "default", rules: &syncRuleSet{rs: compileRuleSet(block, nil)}}}
+	if len(allow) > 0 {
+		x.allowLists = []*NamedList{{ID: "default-allow", rules: &syncRuleSet{rs: compileRuleSet(nil, allow)}}}
+	}
+}
+
 func TestExample(t *testing.T) {
 	x := New()
 	x.Next = test.NextHandler(dns.RcodeSuccess, nil)
@@ -39,8 +49,7 @@ func TestExample(t *testing.T) {
 func TestAllowedDomain(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"bad.domain.": true}}
-	x.allowDomains = &syncMap{m: map[string]bool{"good.domain.": true}}
+	setRules(x, []string{"bad.domain."}, []string{"good.domain."})
 
 	b := &bytes.Buffer{}
 	golog.SetOutput(b)
@@ -59,7 +68,7 @@ func TestAllowedDomain(t *testing.T) {
 func TestBlockedDomain(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"bad.domain.": true}}
+	setRules(x, []string{"bad.domain."}, nil)
 
 	b := &bytes.Buffer{}
 	golog.SetOutput(b)
@@ -78,7 +87,7 @@ func TestBlockedDomain(t *testing.T) {
 func TestBlockedParentDomain(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"bad.domain.": true}}
+	setRules(x, []string{"bad.domain."}, nil)
 
 	b := &bytes.Buffer{}
 	golog.SetOutput(b)
@@ -97,7 +106,7 @@ func TestBlockedParentDomain(t *testing.T) {
 func TestBlockedChildDomain(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"child.bad.domain.": true}}
+	setRules(x, []string{"child.bad.domain."}, nil)
 
 	b := &bytes.Buffer{}
 	golog.SetOutput(b)
@@ -116,7 +125,7 @@ func TestBlockedChildDomain(t *testing.T) {
 func TestBlockedRoot(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{".": true}}
+	setRules(x, []string{"."}, nil)
 
 	b := &bytes.Buffer{}
 	golog.SetOutput(b)
@@ -135,8 +144,7 @@ func TestBlockedRoot(t *testing.T) {
 func TestAllowedDomainWithBlockedParentDomain(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"bad.domain.": true}}
-	x.allowDomains = &syncMap{m: map[string]bool{"sub.good.domain.": true}}
+	setRules(x, []string{"bad.domain."}, []string{"sub.good.domain."})
 
 	b := &bytes.Buffer{}
 	golog.SetOutput(b)
@@ -157,8 +165,7 @@ func TestBlockedDomainWithAllowedParentDomain(t *testing.T) {
 	// block in place
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"sub.bad.domain.": true}}
-	x.allowDomains = &syncMap{m: map[string]bool{"good.domain.": true}}
+	setRules(x, []string{"sub.bad.domain."}, []string{"good.domain."})
 
 	b := &bytes.Buffer{}
 	golog.SetOutput(b)
@@ -177,8 +184,7 @@ func TestBlockedDomainWithAllowedParentDomain(t *testing.T) {
 func TestAllowedDomainWithDomainMetrics(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"bad.domain.": true}}
-	x.allowDomains = &syncMap{m: map[string]bool{"allow.bad.domain.": true}}
+	setRules(x, []string{"bad.domain."}, []string{"allow.bad.domain."})
 	x.domainMetrics = true
 
 	b := &bytes.Buffer{}
@@ -198,7 +204,7 @@ func TestAllowedDomainWithDomainMetrics(t *testing.T) {
 func TestBlockedDomainWithDomainMetrics(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"bad.domain.": true}}
+	setRules(x, []string{"bad.domain."}, nil)
 	x.domainMetrics = true
 
 	b := &bytes.Buffer{}
@@ -218,7 +224,7 @@ func TestBlockedDomainWithDomainMetrics(t *testing.T) {
 func TestBlockedLocalhostStillAllowed(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"localhost.": true}}
+	setRules(x, []string{"localhost."}, nil)
 
 	b := &bytes.Buffer{}
 	golog.SetOutput(b)
@@ -237,7 +243,7 @@ func TestBlockedLocalhostStillAllowed(t *testing.T) {
 func TestBlockedDomainWithNxdomain(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"bad.domain.": true}}
+	setRules(x, []string{"bad.domain."}, nil)
 	x.blockResponse = dns.RcodeNameError
 
 	b := &bytes.Buffer{}
@@ -254,10 +260,109 @@ func TestBlockedDomainWithNxdomain(t *testing.T) {
 	assert.Equal(t, dns.RcodeNameError, rec.Rcode)
 }
 
+func cnameChainHandler(chain ...string) test.Handler {
+	return test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		res := new(dns.Msg)
+		res.SetReply(r)
+
+		qname := r.Question[0].Name
+		for _, target := range chain {
+			res.Answer = append(res.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: qname, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+				Target: target,
+			})
+			qname = target
+		}
+
+		return dns.RcodeSuccess, w.WriteMsg(res)
+	})
+}
+
+func TestCNAMEChainToBlockedDomain(t *testing.T) {
+	x := New()
+	x.Next = cnameChainHandler("cdn.example.net.", "tracker.bad.domain.")
+	setRules(x, []string{"bad.domain."}, nil)
+	x.cnameCheck = true
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("good.domain.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, x.blockResponse, rec.Rcode)
+}
+
+func TestCNAMEChainToAllowedDomain(t *testing.T) {
+	x := New()
+	x.Next = cnameChainHandler("cdn.example.net.", "tracker.bad.domain.")
+	setRules(x, []string{"bad.domain."}, []string{"tracker.bad.domain."})
+	x.cnameCheck = true
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("good.domain.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeSuccess, rec.Rcode)
+}
+
+func TestAllowedQNAMEIsNotBlockedByCNAMETarget(t *testing.T) {
+	// The allowlist always wins: a QNAME that is itself allowlisted must
+	// resolve normally even if its CNAME chain leads to a blocked name.
+	x := New()
+	x.Next = cnameChainHandler("cdn.example.net.", "tracker.bad.domain.")
+	setRules(x, []string{"bad.domain."}, []string{"good.domain."})
+	x.cnameCheck = true
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("good.domain.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeSuccess, rec.Rcode)
+}
+
+func TestCNAMECheckDisabledPassesBlockedTarget(t *testing.T) {
+	x := New()
+	x.Next = cnameChainHandler("tracker.bad.domain.")
+	setRules(x, []string{"bad.domain."}, nil)
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("good.domain.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeSuccess, rec.Rcode)
+}
+
 func TestBlockedDomainWithRefused(t *testing.T) {
 	x := New()
 	x.Next = NextHandler()
-	x.blockDomains = &syncMap{m: map[string]bool{"bad.domain.": true}}
+	setRules(x, []string{"bad.domain."}, nil)
 	x.blockResponse = dns.RcodeRefused
 
 	b := &bytes.Buffer{}
@@ -273,3 +378,33 @@ func TestBlockedDomainWithRefused(t *testing.T) {
 
 	assert.Equal(t, dns.RcodeRefused, rec.Rcode)
 }
+
+func TestMultipleBlockListsReportMatchingListID(t *testing.T) {
+	x := New()
+	x.Next = NextHandler()
+	x.blockLists = []*NamedList{
+		{ID: "stevenblack", rules: &syncRuleSet{rs: compileRuleSet([]string{"bad.domain."}, nil)}},
+		{ID: "oisd", rules: &syncRuleSet{rs: compileRuleSet([]string{"other.bad."}, nil)}},
+	}
+
+	isBlocked, isAllowed, listID := x.shouldBlock("other.bad.")
+	assert.True(t, isBlocked)
+	assert.False(t, isAllowed)
+	assert.Equal(t, "oisd", listID)
+}
+
+func TestPersonalAllowlistOverridesAnyBlockList(t *testing.T) {
+	x := New()
+	x.Next = NextHandler()
+	x.blockLists = []*NamedList{
+		{ID: "stevenblack", rules: &syncRuleSet{rs: compileRuleSet([]string{"bad.domain."}, nil)}},
+	}
+	x.allowLists = []*NamedList{
+		{ID: "personal", rules: &syncRuleSet{rs: compileRuleSet(nil, []string{"sub.bad.domain."})}},
+	}
+
+	isBlocked, isAllowed, listID := x.shouldBlock("sub.bad.domain.")
+	assert.False(t, isBlocked)
+	assert.True(t, isAllowed)
+	assert.Equal(t, "personal", listID)
+}
@@ -0,0 +1,128 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	golog "log"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/miekg/dns"
+)
+
+// test.ResponseWriter always reports this as the client's remote address.
+const testRemoteAddr = "10.240.0.1"
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("invalid CIDR %q: %v", cidr, err)
+	}
+	return ipnet
+}
+
+func withECS(r *dns.Msg, ip net.IP) {
+	e := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 32,
+		Address:       ip,
+	}
+	o := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	o.Option = append(o.Option, e)
+	r.Extra = append(r.Extra, o)
+}
+
+func TestClientPolicyBlocksExtraDomainForMatchingSubnet(t *testing.T) {
+	x := New()
+	x.Next = NextHandler()
+	setRules(x, nil, nil)
+	x.clientPolicies = []*ClientPolicy{{
+		Net:   mustCIDR(t, testRemoteAddr+"/24"),
+		Block: &syncRuleSet{rs: compileRuleSet([]string{"kids.blocked."}, nil)},
+	}}
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("kids.blocked.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeNameError, rec.Rcode)
+}
+
+func TestClientPolicyDoesNotApplyOutsideSubnet(t *testing.T) {
+	x := New()
+	x.Next = NextHandler()
+	setRules(x, nil, nil)
+	x.clientPolicies = []*ClientPolicy{{
+		Net:   mustCIDR(t, "192.168.1.0/24"),
+		Block: &syncRuleSet{rs: compileRuleSet([]string{"kids.blocked."}, nil)},
+	}}
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("kids.blocked.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeSuccess, rec.Rcode)
+}
+
+func TestClientPolicyAllowOverridesGlobalBlock(t *testing.T) {
+	x := New()
+	x.Next = NextHandler()
+	setRules(x, []string{"bad.domain."}, nil)
+	x.clientPolicies = []*ClientPolicy{{
+		Net:   mustCIDR(t, testRemoteAddr+"/24"),
+		Allow: &syncRuleSet{rs: compileRuleSet(nil, []string{"bad.domain."})},
+	}}
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("bad.domain.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeSuccess, rec.Rcode)
+}
+
+func TestClientIPPrefersECSOverRemoteAddr(t *testing.T) {
+	x := New()
+	x.Next = NextHandler()
+	setRules(x, nil, nil)
+	x.clientPolicies = []*ClientPolicy{{
+		Net:   mustCIDR(t, "192.168.50.0/24"),
+		Block: &syncRuleSet{rs: compileRuleSet([]string{"kids.blocked."}, nil)},
+	}}
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("kids.blocked.", dns.TypeA)
+	withECS(r, net.ParseIP("192.168.50.7"))
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeNameError, rec.Rcode)
+}
@@ -0,0 +1,94 @@
+package blocklist
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// loadList fetches the raw lines of a block or allow list from a local
+// file or a remote URL. The lines are returned unparsed; compileRuleSet
+// is responsible for understanding their format.
+func loadList(location string, bootstrapDNS string) ([]string, error) {
+	if isHTTPURL(location) {
+		return loadListFromURL(location, bootstrapDNS)
+	}
+
+	return loadListFromFile(location)
+}
+
+func isHTTPURL(location string) bool {
+	u, err := url.Parse(location)
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+func loadListFromFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open list '%s': %v", path, err)
+	}
+	defer f.Close()
+
+	return readLines(f)
+}
+
+func loadListFromURL(location string, bootstrapDNS string) ([]string, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if bootstrapDNS != "" {
+		client.Transport = &http.Transport{DialContext: bootstrapDialContext(bootstrapDNS)}
+	}
+
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch list '%s': %v", location, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching list '%s': %s", location, resp.Status)
+	}
+
+	return readLines(resp.Body)
+}
+
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// bootstrapDialContext returns a DialContext that resolves hostnames via
+// bootstrapDNS instead of the system resolver, so a list URL can be
+// fetched before CoreDNS itself is serving queries.
+func bootstrapDialContext(bootstrapDNS string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 10 * time.Second}
+			return d.DialContext(ctx, network, bootstrapDNS)
+		},
+	}
+
+	dialer := &net.Dialer{Resolver: resolver}
+
+	return dialer.DialContext
+}
@@ -0,0 +1,42 @@
+package blocklist
+
+// NamedList is a single compiled blocklist or allowlist, identified by a
+// stable ID. The ID is surfaced in metrics and debug logs so operators
+// running multiple subscriptions (e.g. StevenBlack, OISD, a personal
+// allowlist) can see which one is responsible for a decision.
+type NamedList struct {
+	ID       string
+	Location string
+
+	allow bool // true for an allowlist, false for a blocklist
+	rules *syncRuleSet
+}
+
+func newBlockList(id, location string) *NamedList {
+	return &NamedList{ID: id, Location: location, rules: &syncRuleSet{rs: newRuleSet()}}
+}
+
+func newAllowList(id, location string) *NamedList {
+	return &NamedList{ID: id, Location: location, allow: true, rules: &syncRuleSet{rs: newRuleSet()}}
+}
+
+// load fetches and compiles the list's rules, swapping them into rules
+// so concurrent lookups keep using the previous snapshot until this
+// completes.
+func (nl *NamedList) load(bootstrapDNS string) {
+	lines, err := loadList(nl.Location, bootstrapDNS)
+	if err != nil {
+		log.Errorf("unable to load list %q: %v", nl.ID, err)
+		return
+	}
+
+	var rs *RuleSet
+	if nl.allow {
+		rs = compileRuleSet(nil, lines)
+	} else {
+		rs = compileRuleSet(lines, nil)
+	}
+	nl.rules.set(rs)
+
+	log.Infof("Loaded list %q with %d entries", nl.ID, rs.BlockRuleCount()+rs.AllowRuleCount())
+}
@@ -0,0 +1,55 @@
+package blocklist
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metricsSubsystem is the Prometheus subsystem all of this plugin's
+// metrics are registered under.
+const metricsSubsystem = "blocklist"
+
+var (
+	// The "client" label is the matching ClientPolicy's network (e.g.
+	// "10.0.0.0/24"), or empty when the query was handled by the global
+	// lists alone, so per-subnet policy effectiveness can be graphed.
+	blockCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: metricsSubsystem,
+		Name:      "block_count_total",
+		Help:      "Counter of requests blocked by the blocklist.",
+	}, []string{"server", "list", "client"})
+
+	allowCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: metricsSubsystem,
+		Name:      "allow_count_total",
+		Help:      "Counter of requests that matched an allowlist.",
+	}, []string{"server", "list", "client"})
+
+	blockWithDomainsCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: metricsSubsystem,
+		Name:      "block_count_with_domains_total",
+		Help:      "Counter of blocked requests, labeled with the matched domain.",
+	}, []string{"server", "domain", "list", "client"})
+
+	allowWithDomainsCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: metricsSubsystem,
+		Name:      "allow_count_with_domains_total",
+		Help:      "Counter of allowed requests, labeled with the matched domain.",
+	}, []string{"server", "domain", "list", "client"})
+
+	// cnameBlockCount counts requests that were blocked because a CNAME
+	// (or the A/AAAA record it resolved to) in the upstream answer
+	// matched the blocklist, even though the original QNAME did not.
+	cnameBlockCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cname_block_count_total",
+		Help:      "Counter of requests blocked based on a blocked CNAME target.",
+	}, []string{"server"})
+)
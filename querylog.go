@@ -0,0 +1,259 @@
+package blocklist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Query log decisions, mirroring the outcomes ServeDNS can reach for a
+// query.
+const (
+	decisionBlocked = "blocked"
+	decisionAllowed = "allowed"
+	decisionPassed  = "passed"
+)
+
+// defaultQuerylogMaxSize is the rotation threshold used when
+// querylog_max_size isn't given.
+const defaultQuerylogMaxSize = 100 * 1024 * 1024
+
+// defaultQuerylogMaxFiles is the number of rotated files kept when
+// querylog_max_files isn't given.
+const defaultQuerylogMaxFiles = 4
+
+// querylogQueueSize bounds the async writer's channel so a slow disk
+// can never make ServeDNS block; once full, new records are dropped.
+const querylogQueueSize = 4096
+
+// querylogRingSize is the number of most recently logged records kept
+// in memory for the HTTP endpoint.
+const querylogRingSize = 1000
+
+// QueryLogRecord is one decision logged by querylog: a block, an
+// allowlist override, or an ordinary pass-through to the next plugin.
+type QueryLogRecord struct {
+	Time          time.Time `json:"time"`
+	Client        string    `json:"client"`
+	QName         string    `json:"qname"`
+	QType         string    `json:"qtype"`
+	Rule          string    `json:"rule,omitempty"`
+	List          string    `json:"list,omitempty"`
+	Decision      string    `json:"decision"`
+	UpstreamRcode string    `json:"upstream_rcode,omitempty"`
+	ElapsedMicros int64     `json:"elapsed_us"`
+}
+
+// queryLogger writes QueryLogRecords to path as newline-delimited JSON
+// on a dedicated goroutine, so ServeDNS never blocks on disk I/O, and
+// rotates the file once it passes maxSize, keeping maxFiles old copies.
+// It also keeps the last querylogRingSize records in memory so they can
+// be served over HTTP without re-reading the file.
+type queryLogger struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	records chan QueryLogRecord
+	done    chan struct{}
+
+	ringMu sync.Mutex
+	ring   []QueryLogRecord
+	next   int
+
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+func newQueryLogger(path string, maxSize int64, maxFiles int) (*queryLogger, error) {
+	ql := &queryLogger{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		records:  make(chan QueryLogRecord, querylogQueueSize),
+		done:     make(chan struct{}),
+	}
+
+	if err := ql.openFile(); err != nil {
+		return nil, err
+	}
+
+	go ql.run()
+
+	return ql, nil
+}
+
+func (ql *queryLogger) openFile() error {
+	f, err := os.OpenFile(ql.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open querylog '%s': %v", ql.path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("unable to stat querylog '%s': %v", ql.path, err)
+	}
+
+	ql.f = f
+	ql.w = bufio.NewWriter(f)
+	ql.size = info.Size()
+
+	return nil
+}
+
+// log enqueues rec to be written asynchronously. It never blocks: if
+// the writer is falling behind, rec is dropped from the file (it still
+// makes it into the in-memory ring for the HTTP endpoint) and the drop
+// is logged.
+func (ql *queryLogger) log(rec QueryLogRecord) {
+	ql.addToRing(rec)
+
+	select {
+	case ql.records <- rec:
+	default:
+		log.Warning("querylog: writer queue full, dropping record")
+	}
+}
+
+func (ql *queryLogger) addToRing(rec QueryLogRecord) {
+	ql.ringMu.Lock()
+	defer ql.ringMu.Unlock()
+
+	if ql.ring == nil {
+		ql.ring = make([]QueryLogRecord, 0, querylogRingSize)
+	}
+
+	if len(ql.ring) < querylogRingSize {
+		ql.ring = append(ql.ring, rec)
+	} else {
+		ql.ring[ql.next] = rec
+	}
+	ql.next = (ql.next + 1) % querylogRingSize
+}
+
+// recent returns up to k of the most recently logged records, oldest
+// first. k <= 0 returns every record currently held.
+func (ql *queryLogger) recent(k int) []QueryLogRecord {
+	ql.ringMu.Lock()
+	defer ql.ringMu.Unlock()
+
+	n := len(ql.ring)
+	if k <= 0 || k > n {
+		k = n
+	}
+
+	out := make([]QueryLogRecord, 0, k)
+	for i := n - k; i < n; i++ {
+		out = append(out, ql.ring[(ql.next+i)%n])
+	}
+
+	return out
+}
+
+func (ql *queryLogger) run() {
+	defer close(ql.done)
+
+	for rec := range ql.records {
+		ql.write(rec)
+	}
+
+	ql.w.Flush()
+	ql.f.Close()
+}
+
+func (ql *queryLogger) write(rec QueryLogRecord) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Errorf("querylog: unable to marshal record: %v", err)
+		return
+	}
+	b = append(b, '\n')
+
+	if _, err := ql.w.Write(b); err != nil {
+		log.Errorf("querylog: write failed: %v", err)
+		return
+	}
+	ql.size += int64(len(b))
+
+	if ql.size >= ql.maxSize {
+		ql.rotate()
+	}
+}
+
+// rotate flushes and closes the current file, shifts path.1..path.N-1
+// to path.2..path.N (dropping anything older than maxFiles), moves path
+// to path.1, and opens a fresh path.
+func (ql *queryLogger) rotate() {
+	ql.w.Flush()
+	ql.f.Close()
+
+	for i := ql.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", ql.path, i), fmt.Sprintf("%s.%d", ql.path, i+1))
+	}
+	os.Rename(ql.path, ql.path+".1")
+
+	if err := ql.openFile(); err != nil {
+		log.Errorf("querylog: unable to reopen after rotation: %v", err)
+	}
+}
+
+// close stops accepting new records and waits for the writer goroutine
+// to flush and close the file.
+func (ql *queryLogger) close() {
+	close(ql.records)
+	<-ql.done
+}
+
+// ServeHTTP streams the last K logged records as a JSON array, K being
+// given by the "k" query parameter (default querylogRingSize), for
+// dashboards that want a lightweight alternative to tailing the file.
+func (ql *queryLogger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k := querylogRingSize
+	if v := r.URL.Query().Get("k"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			k = n
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ql.recent(k)); err != nil {
+		log.Errorf("querylog: unable to encode response: %v", err)
+	}
+}
+
+// parseByteSize parses a size with an optional K/M/G suffix (e.g.
+// "100M") into a byte count.
+func parseByteSize(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	multiplier := int64(1)
+	numPart := s
+
+	switch s[len(s)-1] {
+	case 'K', 'k':
+		multiplier = 1 << 10
+		numPart = s[:len(s)-1]
+	case 'M', 'm':
+		multiplier = 1 << 20
+		numPart = s[:len(s)-1]
+	case 'G', 'g':
+		multiplier = 1 << 30
+		numPart = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(numPart, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s': %v", s, err)
+	}
+
+	return n * multiplier, nil
+}
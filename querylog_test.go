@@ -0,0 +1,225 @@
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	golog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryLoggerWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "querylog.jsonl")
+
+	ql, err := newQueryLogger(path, defaultQuerylogMaxSize, defaultQuerylogMaxFiles)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ql.log(QueryLogRecord{Time: time.Now(), QName: "bad.domain.", Decision: decisionBlocked})
+	ql.log(QueryLogRecord{Time: time.Now(), QName: "good.domain.", Decision: decisionPassed})
+	ql.close()
+
+	f, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	var rec QueryLogRecord
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, "bad.domain.", rec.QName)
+	assert.Equal(t, decisionBlocked, rec.Decision)
+}
+
+func TestQueryLoggerRotatesPastMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "querylog.jsonl")
+
+	ql, err := newQueryLogger(path, 1, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ql.log(QueryLogRecord{Time: time.Now(), QName: "a.domain.", Decision: decisionPassed})
+	ql.log(QueryLogRecord{Time: time.Now(), QName: "b.domain.", Decision: decisionPassed})
+	ql.close()
+
+	_, err = os.Stat(path + ".1")
+	assert.NoError(t, err, "expected querylog to have rotated to %s.1", path)
+}
+
+func TestQueryLoggerRecentReturnsLastKOldestFirst(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "querylog.jsonl")
+
+	ql, err := newQueryLogger(path, defaultQuerylogMaxSize, defaultQuerylogMaxFiles)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ql.close()
+
+	for _, name := range []string{"one.", "two.", "three."} {
+		ql.log(QueryLogRecord{Time: time.Now(), QName: name, Decision: decisionPassed})
+	}
+
+	recent := ql.recent(2)
+	if assert.Len(t, recent, 2) {
+		assert.Equal(t, "two.", recent[0].QName)
+		assert.Equal(t, "three.", recent[1].QName)
+	}
+}
+
+func TestQueryLoggerServeHTTPStreamsRecentRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "querylog.jsonl")
+
+	ql, err := newQueryLogger(path, defaultQuerylogMaxSize, defaultQuerylogMaxFiles)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ql.close()
+
+	ql.log(QueryLogRecord{Time: time.Now(), QName: "bad.domain.", Decision: decisionBlocked})
+
+	req := httptest.NewRequest(http.MethodGet, "/querylog?k=1", nil)
+	rec := httptest.NewRecorder()
+	ql.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var got []QueryLogRecord
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "bad.domain.", got[0].QName)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]int64{
+		"100":  100,
+		"10K":  10 * 1024,
+		"100M": 100 * 1024 * 1024,
+		"1G":   1024 * 1024 * 1024,
+	}
+
+	for in, want := range cases {
+		got, err := parseByteSize(in)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := parseByteSize("not-a-size")
+	assert.Error(t, err)
+}
+
+func TestServeDNSLogsBlockedDecision(t *testing.T) {
+	x := New()
+	x.Next = NextHandler()
+	setRules(x, []string{"bad.domain."}, nil)
+
+	path := filepath.Join(t.TempDir(), "querylog.jsonl")
+	ql, err := newQueryLogger(path, defaultQuerylogMaxSize, defaultQuerylogMaxFiles)
+	if !assert.NoError(t, err) {
+		return
+	}
+	x.queryLog = ql
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("bad.domain.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+	ql.close()
+
+	recent := ql.recent(1)
+	if assert.Len(t, recent, 1) {
+		assert.Equal(t, decisionBlocked, recent[0].Decision)
+		assert.Equal(t, "bad.domain.", recent[0].QName)
+	}
+}
+
+func TestServeDNSLogsPassedDecisionWithUpstreamRcode(t *testing.T) {
+	x := New()
+	x.Next = NextHandler()
+	setRules(x, nil, nil)
+
+	path := filepath.Join(t.TempDir(), "querylog.jsonl")
+	ql, err := newQueryLogger(path, defaultQuerylogMaxSize, defaultQuerylogMaxFiles)
+	if !assert.NoError(t, err) {
+		return
+	}
+	x.queryLog = ql
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("good.domain.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+	ql.close()
+
+	recent := ql.recent(1)
+	if assert.Len(t, recent, 1) {
+		assert.Equal(t, decisionPassed, recent[0].Decision)
+		assert.Equal(t, "NOERROR", recent[0].UpstreamRcode)
+	}
+}
+
+func TestServeDNSLogsAllowedOnceDespiteBlockedCNAMETarget(t *testing.T) {
+	// Regression test: an allowlisted QNAME whose CNAME chain leads to a
+	// blocked name must produce a single "allowed" record, not an
+	// "allowed" followed by a "blocked" for the same query.
+	x := New()
+	x.Next = cnameChainHandler("cdn.example.net.", "tracker.bad.domain.")
+	setRules(x, []string{"bad.domain."}, []string{"good.domain."})
+	x.cnameCheck = true
+
+	path := filepath.Join(t.TempDir(), "querylog.jsonl")
+	ql, err := newQueryLogger(path, defaultQuerylogMaxSize, defaultQuerylogMaxFiles)
+	if !assert.NoError(t, err) {
+		return
+	}
+	x.queryLog = ql
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("good.domain.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+	ql.close()
+
+	recent := ql.recent(10)
+	if assert.Len(t, recent, 1) {
+		assert.Equal(t, decisionAllowed, recent[0].Decision)
+	}
+}
@@ -0,0 +1,294 @@
+package blocklist
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// ruleNode is a node in the label trie used by RuleSet. Domains are
+// inserted label-by-label starting from the TLD, so that matching a
+// name walks the trie from least to most specific label.
+type ruleNode struct {
+	children map[string]*ruleNode
+	ruleID   int // >0 when this node terminates a rule
+}
+
+func newRuleNode() *ruleNode {
+	return &ruleNode{children: make(map[string]*ruleNode)}
+}
+
+// wildcardLabel is the synthetic trie label used for "*.domain.tld"
+// style rules: it matches any chain of one or more labels below the
+// node it hangs off.
+const wildcardLabel = "*"
+
+// RuleSet is a compiled set of blocklist/allowlist rules. It understands
+// plain hostnames, hosts-file lines, Adblock Plus network filters
+// (including "@@" exceptions) and simple wildcards. Rules are compiled
+// once at load time into a pair of label tries so that Match runs in
+// O(labels) time regardless of how large the source list was.
+type RuleSet struct {
+	block *ruleNode
+	allow *ruleNode
+
+	// rules holds the original rule text, indexed by ruleID, so callers
+	// can log which line matched. Index 0 is reserved for "no match".
+	rules []string
+
+	blockRules int
+	allowRules int
+}
+
+func newRuleSet() *RuleSet {
+	return &RuleSet{
+		block: newRuleNode(),
+		allow: newRuleNode(),
+		rules: []string{""},
+	}
+}
+
+// Match reports whether name is blocked and/or allowed, and the ID of
+// the rule responsible. As with the original map-based implementation,
+// the allow trie always wins over the block trie.
+func (rs *RuleSet) Match(name string) (blocked bool, allowed bool, ruleID int) {
+	if id := matchTrie(rs.allow, name); id > 0 {
+		return false, true, id
+	}
+	if id := matchTrie(rs.block, name); id > 0 {
+		return true, false, id
+	}
+	return false, false, 0
+}
+
+// Rule returns the original rule text for a rule ID returned by Match.
+func (rs *RuleSet) Rule(ruleID int) string {
+	if ruleID <= 0 || ruleID >= len(rs.rules) {
+		return ""
+	}
+	return rs.rules[ruleID]
+}
+
+// BlockRuleCount and AllowRuleCount report how many rules were compiled
+// into each trie, for logging at load time.
+func (rs *RuleSet) BlockRuleCount() int { return rs.blockRules }
+func (rs *RuleSet) AllowRuleCount() int { return rs.allowRules }
+
+// insert adds name to root, terminating on a wildcard marker node
+// instead of name's own node when wildcard is true.
+func (rs *RuleSet) insert(root *ruleNode, name string, wildcard bool, ruleText string) {
+	n := root
+	for _, label := range reverseLabels(name) {
+		child, ok := n.children[label]
+		if !ok {
+			child = newRuleNode()
+			n.children[label] = child
+		}
+		n = child
+	}
+
+	if wildcard {
+		wc, ok := n.children[wildcardLabel]
+		if !ok {
+			wc = newRuleNode()
+			n.children[wildcardLabel] = wc
+		}
+		n = wc
+	}
+
+	n.ruleID = len(rs.rules)
+	rs.rules = append(rs.rules, ruleText)
+
+	if root == rs.allow {
+		rs.allowRules++
+	} else {
+		rs.blockRules++
+	}
+}
+
+// matchTrie walks root from the TLD label down to the most specific
+// label of name, remembering the deepest rule seen. This preserves the
+// original suffix-match behavior: a rule for "bad.domain." also matches
+// "child.bad.domain.", and a more specific rule wins over a broader one.
+func matchTrie(root *ruleNode, name string) int {
+	n := root
+	last := n.ruleID
+
+	for _, label := range reverseLabels(name) {
+		if wc, ok := n.children[wildcardLabel]; ok {
+			last = wc.ruleID
+		}
+
+		child, ok := n.children[label]
+		if !ok {
+			return last
+		}
+
+		n = child
+		if n.ruleID > 0 {
+			last = n.ruleID
+		}
+	}
+
+	return last
+}
+
+// reverseLabels splits a (possibly root-terminated) DNS name into its
+// labels, ordered from TLD to most specific, e.g. "a.b.example.com." ->
+// []string{"com", "example", "b", "a"}.
+func reverseLabels(name string) []string {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil
+	}
+
+	labels := strings.Split(name, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+
+	return labels
+}
+
+// compileRuleSet compiles the raw lines of a blocklist and an optional
+// allowlist into a RuleSet. "@@" exception lines found in the blocklist
+// are routed to the allow trie, matching Adblock Plus semantics.
+func compileRuleSet(blockLines, allowLines []string) *RuleSet {
+	rs := newRuleSet()
+
+	for _, line := range blockLines {
+		domain, wildcard, exception, ok := parseRuleLine(line)
+		if !ok {
+			continue
+		}
+
+		root := rs.block
+		if exception {
+			root = rs.allow
+		}
+
+		rs.insert(root, domain, wildcard, line)
+	}
+
+	for _, line := range allowLines {
+		domain, wildcard, _, ok := parseRuleLine(line)
+		if !ok {
+			continue
+		}
+
+		rs.insert(rs.allow, domain, wildcard, line)
+	}
+
+	return rs
+}
+
+// parseRuleLine classifies a single line from a block/allow list,
+// detecting its format and extracting the domain it targets:
+//
+//   - comments ("#" or "!") and blank lines are skipped (ok == false)
+//   - hosts-file lines ("0.0.0.0 evil.com") use the second field
+//   - Adblock Plus network filters ("||example.com^") strip the anchor
+//     and any trailing filter options/path
+//   - "@@" exception rules are reported via the exception return value
+//   - wildcards ("*.doubleclick.net") are reported via wildcard
+//   - anything else is treated as a bare hostname, matched (as before)
+//     against itself and all of its subdomains
+func parseRuleLine(line string) (domain string, wildcard bool, exception bool, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+		return "", false, false, false
+	}
+
+	if strings.HasPrefix(line, "@@") {
+		exception = true
+		line = strings.TrimPrefix(line, "@@")
+	}
+
+	switch {
+	case strings.HasPrefix(line, "||"):
+		line = strings.TrimPrefix(line, "||")
+		if idx := strings.IndexAny(line, "^/"); idx >= 0 {
+			line = line[:idx]
+		}
+	case isHostsLine(line):
+		line = strings.Fields(line)[1]
+	}
+
+	if line == "." {
+		// The DNS root, used to block/allow everything.
+		return line, wildcard, exception, true
+	}
+
+	if strings.HasPrefix(line, "*.") {
+		wildcard = true
+		line = strings.TrimPrefix(line, "*.")
+	}
+
+	line = strings.TrimSuffix(line, ".")
+	if line == "" {
+		return "", false, false, false
+	}
+
+	return dns.Fqdn(line), wildcard, exception, true
+}
+
+// isHostsLine reports whether line looks like a "hosts" file entry,
+// i.e. an IP address followed by a hostname.
+func isHostsLine(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return false
+	}
+
+	return net.ParseIP(fields[0]) != nil
+}
+
+// syncRuleSet lets readBlocklist swap in a freshly compiled RuleSet from
+// the periodic reload goroutine while ServeDNS keeps matching against
+// the previous snapshot.
+type syncRuleSet struct {
+	mu sync.RWMutex
+	rs *RuleSet
+}
+
+func (s *syncRuleSet) set(rs *RuleSet) {
+	s.mu.Lock()
+	s.rs = rs
+	s.mu.Unlock()
+}
+
+func (s *syncRuleSet) match(name string) (blocked bool, allowed bool, ruleID int) {
+	if s == nil {
+		return false, false, 0
+	}
+
+	s.mu.RLock()
+	rs := s.rs
+	s.mu.RUnlock()
+
+	if rs == nil {
+		return false, false, 0
+	}
+
+	return rs.Match(name)
+}
+
+// rule returns the literal rule text that matches name, for querylog.
+func (s *syncRuleSet) rule(name string) string {
+	if s == nil {
+		return ""
+	}
+
+	s.mu.RLock()
+	rs := s.rs
+	s.mu.RUnlock()
+
+	if rs == nil {
+		return ""
+	}
+
+	_, _, id := rs.Match(name)
+	return rs.Rule(id)
+}
@@ -0,0 +1,133 @@
+package blocklist
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleSetPlainHostname(t *testing.T) {
+	rs := compileRuleSet([]string{"bad.domain."}, nil)
+
+	blocked, allowed, _ := rs.Match("bad.domain.")
+	assert.True(t, blocked)
+	assert.False(t, allowed)
+
+	blocked, _, _ = rs.Match("child.bad.domain.")
+	assert.True(t, blocked)
+
+	blocked, _, _ = rs.Match("good.domain.")
+	assert.False(t, blocked)
+}
+
+func TestRuleSetHostsLine(t *testing.T) {
+	rs := compileRuleSet([]string{"0.0.0.0 evil.com", "# a comment", "! also a comment", ""}, nil)
+
+	blocked, _, _ := rs.Match("evil.com.")
+	assert.True(t, blocked)
+
+	blocked, _, _ = rs.Match("good.com.")
+	assert.False(t, blocked)
+}
+
+func TestRuleSetAdblockNetworkFilter(t *testing.T) {
+	rs := compileRuleSet([]string{"||ads.example.com^"}, nil)
+
+	blocked, _, _ := rs.Match("ads.example.com.")
+	assert.True(t, blocked)
+
+	blocked, _, _ = rs.Match("example.com.")
+	assert.False(t, blocked)
+}
+
+func TestRuleSetAdblockWildcard(t *testing.T) {
+	rs := compileRuleSet([]string{"||*.tracker.com^"}, nil)
+
+	blocked, _, _ := rs.Match("eu.tracker.com.")
+	assert.True(t, blocked)
+
+	blocked, _, _ = rs.Match("tracker.com.")
+	assert.False(t, blocked, "a subdomain wildcard must not match the bare domain")
+}
+
+func TestRuleSetSimpleWildcard(t *testing.T) {
+	rs := compileRuleSet([]string{"*.doubleclick.net"}, nil)
+
+	blocked, _, _ := rs.Match("ads.doubleclick.net.")
+	assert.True(t, blocked)
+
+	blocked, _, _ = rs.Match("doubleclick.net.")
+	assert.False(t, blocked)
+}
+
+func TestRuleSetAdblockException(t *testing.T) {
+	rs := compileRuleSet([]string{"||example.com^", "@@||allowed.example.com^"}, nil)
+
+	blocked, allowed, _ := rs.Match("evil.example.com.")
+	assert.True(t, blocked)
+	assert.False(t, allowed)
+
+	blocked, allowed, _ = rs.Match("allowed.example.com.")
+	assert.False(t, blocked)
+	assert.True(t, allowed)
+}
+
+func TestRuleSetSeparateAllowlist(t *testing.T) {
+	rs := compileRuleSet([]string{"bad.domain."}, []string{"good.bad.domain."})
+
+	blocked, allowed, _ := rs.Match("good.bad.domain.")
+	assert.False(t, blocked)
+	assert.True(t, allowed)
+}
+
+func TestRuleSetRootBlocksEverything(t *testing.T) {
+	rs := compileRuleSet([]string{"."}, nil)
+
+	blocked, _, _ := rs.Match("anything.at.all.")
+	assert.True(t, blocked)
+}
+
+func TestRuleSetRuleLookup(t *testing.T) {
+	rs := compileRuleSet([]string{"||ads.example.com^"}, nil)
+
+	_, _, ruleID := rs.Match("ads.example.com.")
+	assert.Equal(t, "||ads.example.com^", rs.Rule(ruleID))
+}
+
+// BenchmarkRuleSetMatch vs. BenchmarkMapLookup compares the trie-based
+// RuleSet against the flat map[string]bool lookup it replaced, on a
+// 100k-entry list.
+func buildLargeList(n int) []string {
+	list := make([]string, n)
+	for i := 0; i < n; i++ {
+		list[i] = "sub" + strconv.Itoa(i) + ".blocked-" + strconv.Itoa(i) + ".example."
+	}
+	return list
+}
+
+func BenchmarkRuleSetMatch(b *testing.B) {
+	list := buildLargeList(100000)
+	rs := compileRuleSet(list, nil)
+	name := fmt.Sprintf("sub%d.blocked-%d.example.", 99999, 99999)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rs.Match(name)
+	}
+}
+
+func BenchmarkMapLookup(b *testing.B) {
+	list := buildLargeList(100000)
+	m := make(map[string]bool, len(list))
+	for _, l := range list {
+		m[l] = true
+	}
+	name := fmt.Sprintf("sub%d.blocked-%d.example.", 99999, 99999)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = m[name]
+	}
+}
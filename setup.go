@@ -2,9 +2,12 @@ package blocklist
 
 import (
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/coredns/caddy"
@@ -69,13 +72,16 @@ func setup(c *caddy.Controller) error {
 
 		c.OnStartup(func() error {
 			b.readBlocklist()
-			return nil
+			return startQueryLog(b)
 		})
 
 		c.OnShutdown(func() error {
 			close(parseChan)
+			if b.queryLog != nil {
+				b.queryLog.close()
+			}
 			return nil
-	  })
+		})
 	}
 
 	return nil
@@ -88,10 +94,72 @@ func getBlockResponseCode(blockResponse string) (int, error) {
 	case "refused":
 		return dns.RcodeRefused, nil
 	default:
-		return 0, fmt.Errorf("unknown response code '%s', must be either 'nxdomain' or 'refused'", blockResponse)
+		return 0, fmt.Errorf("unknown response code '%s', must be either 'nxdomain', 'refused' or 'sinkhole'", blockResponse)
 	}
 }
 
+// parseSinkholeArgs parses the IPv4 address, and optional IPv6 address,
+// that follow "block_response sinkhole".
+func parseSinkholeArgs(args []string) (v4 net.IP, v6 net.IP, err error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, nil, fmt.Errorf("block_response sinkhole requires an IPv4 address and an optional IPv6 address")
+	}
+
+	v4 = net.ParseIP(args[0])
+	if v4 == nil || v4.To4() == nil {
+		return nil, nil, fmt.Errorf("invalid IPv4 address '%s' for block_response sinkhole", args[0])
+	}
+
+	if len(args) == 2 {
+		v6 = net.ParseIP(args[1])
+		if v6 == nil || v6.To4() != nil {
+			return nil, nil, fmt.Errorf("invalid IPv6 address '%s' for block_response sinkhole", args[1])
+		}
+	}
+
+	return v4, v6, nil
+}
+
+// startQueryLog opens b's querylog file, if configured, and starts the
+// HTTP endpoint that streams its recent records, if a querylog_http
+// address was also given.
+//
+// querylog_http is its own plain net/http listener, not a handler
+// mounted on CoreDNS's existing metrics listener: plugin/metrics.Metrics
+// keeps its http.ServeMux unexported and offers no hook for another
+// plugin to register additional routes on it, so there is no supported
+// way to attach this endpoint to the /metrics port. Pick a querylog_http
+// address distinct from the metrics plugin's.
+func startQueryLog(b *Blocklist) error {
+	if b.querylogPath == "" {
+		return nil
+	}
+
+	ql, err := newQueryLogger(b.querylogPath, b.querylogMaxSize, b.querylogMaxFiles)
+	if err != nil {
+		return err
+	}
+	b.queryLog = ql
+
+	if b.querylogHTTPAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/querylog", ql)
+
+		ln, err := net.Listen("tcp", b.querylogHTTPAddr)
+		if err != nil {
+			return fmt.Errorf("unable to listen for querylog_http on '%s': %v", b.querylogHTTPAddr, err)
+		}
+
+		go func() {
+			if err := http.Serve(ln, mux); err != nil {
+				log.Errorf("querylog HTTP endpoint stopped: %v", err)
+			}
+		}()
+	}
+
+	return nil
+}
+
 func parseBlocklist(c *caddy.Controller) ([]*Blocklist, error) {
 	bl := []*Blocklist{}
 
@@ -133,50 +201,131 @@ func parseStanza(c *caddy.Controller) (*Blocklist, error) {
 	b := New()
 	config := dnsserver.GetConfig(c)
 
-	if !c.Args(&b.blocklistLocation) {
-		return b, c.ArgErr()
-	}
-
-	// check blocklist location
-	filename, err := checkFileorURL(b.blocklistLocation, config.Root)
-	if err != nil {
-		return b, err
+	// The original, single-list syntax is still supported: a location
+	// given directly on the "blocklist" line becomes an unnamed default
+	// list, on top of which further named lists can be added below.
+	if c.NextArg() {
+		location, err := checkFileorURL(c.Val(), config.Root)
+		if err != nil {
+			return b, err
+		}
+		b.blockLists = append(b.blockLists, newBlockList("default", location))
 	}
 
-	b.blocklistLocation = filename
-
 	for c.NextBlock() {
 		option := c.Val()
 		switch option {
+		case "blocklist":
+			id, location, err := namedListArgs(c.RemainingArgs(), "blocklist")
+			if err != nil {
+				return b, err
+			}
+
+			location, err = checkFileorURL(location, config.Root)
+			if err != nil {
+				return b, err
+			}
+
+			log.Debugf("Adding blocklist %q at %s", id, location)
+			b.blockLists = append(b.blockLists, newBlockList(id, location))
 		case "allowlist":
-			remaining := c.RemainingArgs()
-			if len(remaining) != 1 {
-				return b, fmt.Errorf("allowlist requires a single argument.")
+			id, location, err := namedListArgs(c.RemainingArgs(), "allowlist")
+			if err != nil {
+				return b, err
+			}
+
+			location, err = checkFileorURL(location, config.Root)
+			if err != nil {
+				return b, err
 			}
 
-			b.allowlistLocation = remaining[0]
-			// check if file or url and check reachability
-			b.allowlistLocation, err = checkFileorURL(b.allowlistLocation, config.Root)
+			log.Debugf("Adding allowlist %q at %s", id, location)
+			b.allowLists = append(b.allowLists, newAllowList(id, location))
+		case "client":
+			policy, err := parseClientPolicy(c, config.Root)
 			if err != nil {
 				return b, err
 			}
 
-			log.Debugf("Setting allowlist location to %s", b.allowlistLocation)
+			log.Debugf("Adding client policy for %s", policy.Net)
+			b.clientPolicies = append(b.clientPolicies, policy)
 		case "domain_metrics":
 			b.domainMetrics = true
+		case "cname_check":
+			b.cnameCheck = true
 		case "bootstrap_dns":
 			b.bootStrapDNS = c.RemainingArgs()[0]
 		case "block_response":
+			remaining := c.RemainingArgs()
+			if len(remaining) == 0 {
+				return b, fmt.Errorf("block_response requires at least one argument.")
+			}
+
+			if remaining[0] == "sinkhole" {
+				v4, v6, err := parseSinkholeArgs(remaining[1:])
+				if err != nil {
+					return b, err
+				}
+
+				b.sinkhole = true
+				b.sinkholeV4 = v4
+				b.sinkholeV6 = v6
+			} else {
+				if len(remaining) != 1 {
+					return b, fmt.Errorf("block_response requires a single argument.")
+				}
+
+				blockResponseCode, err := getBlockResponseCode(remaining[0])
+				if err != nil {
+					return b, err
+				}
+				b.blockResponse = blockResponseCode
+			}
+		case "querylog":
 			remaining := c.RemainingArgs()
 			if len(remaining) != 1 {
-				return b, fmt.Errorf("block_response requires a single argument.")
+				return b, fmt.Errorf("querylog requires a single path argument.")
+			}
+			b.querylogPath = remaining[0]
+		case "querylog_max_size":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return b, fmt.Errorf("querylog_max_size requires a single argument.")
 			}
 
-			blockResponseCode, err := getBlockResponseCode(remaining[0])
+			size, err := parseByteSize(remaining[0])
 			if err != nil {
-				return b, err
+				return b, fmt.Errorf("invalid querylog_max_size '%s': %v", remaining[0], err)
 			}
-			b.blockResponse = blockResponseCode
+			b.querylogMaxSize = size
+		case "querylog_max_files":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return b, fmt.Errorf("querylog_max_files requires a single argument.")
+			}
+
+			n, err := strconv.Atoi(remaining[0])
+			if err != nil || n < 1 {
+				return b, fmt.Errorf("invalid querylog_max_files '%s', must be a positive integer", remaining[0])
+			}
+			b.querylogMaxFiles = n
+		case "querylog_http":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return b, fmt.Errorf("querylog_http requires a single address argument.")
+			}
+			b.querylogHTTPAddr = remaining[0]
+		case "block_ttl":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return b, fmt.Errorf("block_ttl requires a single argument.")
+			}
+
+			ttl, err := strconv.ParseUint(remaining[0], 10, 32)
+			if err != nil {
+				return b, fmt.Errorf("invalid block_ttl '%s': %v", remaining[0], err)
+			}
+			b.blockTTL = uint32(ttl)
 		case "reload":
 			remaining := c.RemainingArgs()
 			if len(remaining) != 1 {
@@ -199,5 +348,79 @@ func parseStanza(c *caddy.Controller) (*Blocklist, error) {
 		return b, fmt.Errorf("To many arguments for blocklist.")
 	}
 
+	if len(b.blockLists) == 0 {
+		return b, fmt.Errorf("blocklist requires at least one list, e.g. 'blocklist <file>' or 'blocklist <name> <file>' inside the block")
+	}
+
+	if b.querylogHTTPAddr != "" && b.querylogPath == "" {
+		return b, fmt.Errorf("querylog_http requires querylog to also be set")
+	}
+
 	return b, nil
 }
+
+// parseClientPolicy parses a:
+//
+//	client <CIDR> {
+//	    blocklist <location>
+//	    allowlist <location>
+//	}
+//
+// sub-stanza, scoping its block/allowlist to queries from clients within
+// CIDR.
+func parseClientPolicy(c *caddy.Controller, rootdir string) (*ClientPolicy, error) {
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return nil, fmt.Errorf("client requires a single CIDR argument.")
+	}
+
+	_, ipnet, err := net.ParseCIDR(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid client CIDR '%s': %v", args[0], err)
+	}
+
+	policy := &ClientPolicy{
+		Net:   ipnet,
+		Block: &syncRuleSet{rs: newRuleSet()},
+		Allow: &syncRuleSet{rs: newRuleSet()},
+	}
+
+	for c.NextBlock() {
+		sub := c.Val()
+		remaining := c.RemainingArgs()
+		if len(remaining) != 1 {
+			return nil, fmt.Errorf("%s requires a single argument.", sub)
+		}
+
+		location, err := checkFileorURL(remaining[0], rootdir)
+		if err != nil {
+			return nil, err
+		}
+
+		switch sub {
+		case "blocklist":
+			policy.blockLocation = location
+		case "allowlist":
+			policy.allowLocation = location
+		default:
+			return nil, fmt.Errorf("unexpected '%v' command inside client block", sub)
+		}
+	}
+
+	return policy, nil
+}
+
+// namedListArgs parses the arguments of a "blocklist <name> <location>"
+// or "allowlist <name> <location>" sub-directive. A single argument is
+// also accepted for backward compatibility, in which case the
+// directive name itself is used as the list's ID.
+func namedListArgs(args []string, directive string) (id string, location string, err error) {
+	switch len(args) {
+	case 1:
+		return directive, args[0], nil
+	case 2:
+		return args[0], args[1], nil
+	default:
+		return "", "", fmt.Errorf("%s requires either '<location>' or '<name> <location>'", directive)
+	}
+}
@@ -0,0 +1,69 @@
+package blocklist
+
+import "github.com/miekg/dns"
+
+// defaultSOA is returned in the authority section of a sinkholed reply
+// for QTYPEs other than A/AAAA, mirroring the negative-caching SOA
+// record AdGuard Home uses for nonexistent zones.
+var defaultSOA = &dns.SOA{
+	Hdr:     dns.RR_Header{Rrtype: dns.TypeSOA, Class: dns.ClassINET},
+	Ns:      "fake-for-negative-caching.adguard.com.",
+	Mbox:    "hostmaster.fake-for-negative-caching.adguard.com.",
+	Serial:  100500,
+	Refresh: 1800,
+	Retry:   900,
+	Expire:  604800,
+	Minttl:  86400,
+}
+
+// blockResponseMsg builds the reply sent for a blocked query, following
+// the configured block_response mode.
+func (b Blocklist) blockResponseMsg(r *dns.Msg) *dns.Msg {
+	if b.sinkhole {
+		return b.sinkholeResponse(r)
+	}
+
+	resp := new(dns.Msg)
+	resp.SetRcode(r, b.blockResponse)
+	return resp
+}
+
+// sinkholeResponse synthesizes an A or AAAA answer pointing at the
+// configured sinkhole address when it matches the query's QTYPE. For
+// any other QTYPE, or when the matching address family wasn't
+// configured, it returns a NOERROR reply with an empty answer section
+// and an SOA in the authority section, so resolvers still cache the
+// negative result for blockTTL seconds.
+func (b Blocklist) sinkholeResponse(r *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+	resp.Authoritative = true
+
+	q := r.Question[0]
+
+	switch q.Qtype {
+	case dns.TypeA:
+		if b.sinkholeV4 != nil {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: b.blockTTL},
+				A:   b.sinkholeV4,
+			})
+			return resp
+		}
+	case dns.TypeAAAA:
+		if b.sinkholeV6 != nil {
+			resp.Answer = append(resp.Answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: q.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: b.blockTTL},
+				AAAA: b.sinkholeV6,
+			})
+			return resp
+		}
+	}
+
+	soa := *b.soa
+	soa.Hdr.Name = q.Name
+	soa.Hdr.Ttl = b.blockTTL
+	resp.Ns = append(resp.Ns, &soa)
+
+	return resp
+}
@@ -0,0 +1,136 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	golog "log"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/miekg/dns"
+)
+
+func newSinkholeBlocklist() *Blocklist {
+	x := New()
+	x.Next = NextHandler()
+	setRules(x, []string{"bad.domain."}, nil)
+	x.sinkhole = true
+	x.sinkholeV4 = net.ParseIP("0.0.0.0")
+	x.sinkholeV6 = net.ParseIP("::")
+	x.blockTTL = 60
+	return x
+}
+
+func TestSinkholeRespondsWithA(t *testing.T) {
+	x := newSinkholeBlocklist()
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("bad.domain.", dns.TypeA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeSuccess, rec.Rcode)
+	if assert.Len(t, rec.Msg.Answer, 1) {
+		a, ok := rec.Msg.Answer[0].(*dns.A)
+		if assert.True(t, ok) {
+			assert.Equal(t, "0.0.0.0", a.A.String())
+			assert.EqualValues(t, 60, a.Hdr.Ttl)
+		}
+	}
+}
+
+func TestSinkholeRespondsWithAAAA(t *testing.T) {
+	x := newSinkholeBlocklist()
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("bad.domain.", dns.TypeAAAA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeSuccess, rec.Rcode)
+	if assert.Len(t, rec.Msg.Answer, 1) {
+		aaaa, ok := rec.Msg.Answer[0].(*dns.AAAA)
+		if assert.True(t, ok) {
+			assert.Equal(t, "::", aaaa.AAAA.String())
+		}
+	}
+}
+
+func TestSinkholeRespondsWithSOAForMX(t *testing.T) {
+	x := newSinkholeBlocklist()
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("bad.domain.", dns.TypeMX)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeSuccess, rec.Rcode)
+	assert.Empty(t, rec.Msg.Answer)
+	if assert.Len(t, rec.Msg.Ns, 1) {
+		soa, ok := rec.Msg.Ns[0].(*dns.SOA)
+		if assert.True(t, ok) {
+			assert.Equal(t, "bad.domain.", soa.Hdr.Name)
+			assert.EqualValues(t, 60, soa.Hdr.Ttl)
+		}
+	}
+}
+
+func TestSinkholeRespondsWithSOAForHTTPS(t *testing.T) {
+	x := newSinkholeBlocklist()
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("bad.domain.", dns.TypeHTTPS)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeSuccess, rec.Rcode)
+	assert.Empty(t, rec.Msg.Answer)
+	assert.Len(t, rec.Msg.Ns, 1)
+}
+
+func TestSinkholeWithoutV6FallsBackToSOAForAAAA(t *testing.T) {
+	x := New()
+	x.Next = NextHandler()
+	setRules(x, []string{"bad.domain."}, nil)
+	x.sinkhole = true
+	x.sinkholeV4 = net.ParseIP("0.0.0.0")
+	x.blockTTL = 60
+
+	b := &bytes.Buffer{}
+	golog.SetOutput(b)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("bad.domain.", dns.TypeAAAA)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	x.ServeDNS(ctx, rec, r)
+
+	assert.Equal(t, dns.RcodeSuccess, rec.Rcode)
+	assert.Empty(t, rec.Msg.Answer)
+	assert.Len(t, rec.Msg.Ns, 1)
+}